@@ -0,0 +1,196 @@
+package eventsource
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverMetrics holds the per-channel Prometheus instrumentation for a Server. All methods
+// are nil-safe so that a Server which never calls Collector() pays no collection overhead.
+type serverMetrics struct {
+	activeSubscribers    *prometheus.GaugeVec
+	subscriptionsOpened  *prometheus.CounterVec
+	subscriptionsDropped *prometheus.CounterVec
+	eventsDropped        *prometheus.CounterVec
+	eventsPublished      *prometheus.CounterVec
+	commentsPublished    *prometheus.CounterVec
+	bytesWritten         *prometheus.CounterVec
+	encodeErrors         *prometheus.CounterVec
+	replaysRequested     *prometheus.CounterVec
+	replaysStarted       *prometheus.CounterVec
+	replayEventsEmitted  *prometheus.CounterVec
+	publishQueueDepth    prometheus.Gauge
+}
+
+func newServerMetrics(namespace string) *serverMetrics {
+	labels := []string{"channel"}
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+	return &serverMetrics{
+		activeSubscribers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_subscribers",
+			Help:      "Number of currently connected subscribers, by channel.",
+		}, labels),
+		subscriptionsOpened:  counter("subscriptions_opened_total", "Total subscriptions opened, by channel."),
+		subscriptionsDropped: counter("subscriptions_dropped_total", "Total subscriptions dropped because their buffer was full, by channel."),
+		eventsDropped:        counter("events_dropped_total", "Total events discarded by PolicyDropOldest (the oldest buffered event) or PolicyDropNewest (the new event) to keep a slow subscriber connected, by channel."),
+		eventsPublished:      counter("events_published_total", "Total events published, by channel."),
+		commentsPublished:    counter("comments_published_total", "Total comments published, by channel."),
+		bytesWritten:         counter("bytes_written_total", "Total bytes written to subscriber connections, by channel."),
+		encodeErrors:         counter("encode_errors_total", "Total errors encoding an event or comment to a subscriber, by channel."),
+		replaysRequested:     counter("replays_requested_total", "Total subscriber connections that requested a replay, by channel. May be served by a cached snapshot rather than a new Repository.Replay invocation; compare against replays_started_total to see the cache's effect."),
+		replaysStarted:       counter("replays_started_total", "Total Repository.Replay invocations, by channel."),
+		replayEventsEmitted:  counter("replay_events_emitted_total", "Total events emitted by replays, by channel."),
+		publishQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "publish_queue_depth",
+			Help:      "Current depth of the asynchronous publish ingress queue.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *serverMetrics) Describe(ch chan<- *prometheus.Desc) {
+	if m == nil {
+		return
+	}
+	m.activeSubscribers.Describe(ch)
+	m.subscriptionsOpened.Describe(ch)
+	m.subscriptionsDropped.Describe(ch)
+	m.eventsDropped.Describe(ch)
+	m.eventsPublished.Describe(ch)
+	m.commentsPublished.Describe(ch)
+	m.bytesWritten.Describe(ch)
+	m.encodeErrors.Describe(ch)
+	m.replaysRequested.Describe(ch)
+	m.replaysStarted.Describe(ch)
+	m.replayEventsEmitted.Describe(ch)
+	m.publishQueueDepth.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *serverMetrics) Collect(ch chan<- prometheus.Metric) {
+	if m == nil {
+		return
+	}
+	m.activeSubscribers.Collect(ch)
+	m.subscriptionsOpened.Collect(ch)
+	m.subscriptionsDropped.Collect(ch)
+	m.eventsDropped.Collect(ch)
+	m.eventsPublished.Collect(ch)
+	m.commentsPublished.Collect(ch)
+	m.bytesWritten.Collect(ch)
+	m.encodeErrors.Collect(ch)
+	m.replaysRequested.Collect(ch)
+	m.replaysStarted.Collect(ch)
+	m.replayEventsEmitted.Collect(ch)
+	m.publishQueueDepth.Collect(ch)
+}
+
+func (m *serverMetrics) subscriptionOpened(channel string) {
+	if m == nil {
+		return
+	}
+	m.subscriptionsOpened.WithLabelValues(channel).Inc()
+	m.activeSubscribers.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) subscriptionClosed(channel string) {
+	if m == nil {
+		return
+	}
+	m.activeSubscribers.WithLabelValues(channel).Dec()
+}
+
+func (m *serverMetrics) subscriptionDropped(channel string) {
+	if m == nil {
+		return
+	}
+	m.subscriptionsDropped.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) eventDropped(channel string) {
+	if m == nil {
+		return
+	}
+	m.eventsDropped.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) eventPublished(channel string) {
+	if m == nil {
+		return
+	}
+	m.eventsPublished.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) commentPublished(channel string) {
+	if m == nil {
+		return
+	}
+	m.commentsPublished.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) bytesWrittenTo(channel string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.bytesWritten.WithLabelValues(channel).Add(float64(n))
+}
+
+func (m *serverMetrics) encodeError(channel string) {
+	if m == nil {
+		return
+	}
+	m.encodeErrors.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) replayRequested(channel string) {
+	if m == nil {
+		return
+	}
+	m.replaysRequested.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) replayStarted(channel string) {
+	if m == nil {
+		return
+	}
+	m.replaysStarted.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) replayEventEmitted(channel string) {
+	if m == nil {
+		return
+	}
+	m.replayEventsEmitted.WithLabelValues(channel).Inc()
+}
+
+func (m *serverMetrics) setPublishQueueDepth(n int) {
+	if m == nil {
+		return
+	}
+	m.publishQueueDepth.Set(float64(n))
+}
+
+// meteredWriter wraps an io.Writer, reporting the number of bytes written on each Write that
+// writes any, even one that also returns an error, so Handler can track per-channel bytes written without the Encoder needing to know
+// about metrics.
+type meteredWriter struct {
+	w       io.Writer
+	onWrite func(n int)
+}
+
+func (m meteredWriter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	if n > 0 {
+		m.onWrite(n)
+	}
+	return n, err
+}
@@ -1,15 +1,82 @@
 package eventsource
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// defaultPublishQueueSize is used when Server.PublishQueueSize is left at its zero value.
+const defaultPublishQueueSize = 64
+
+// subscription tracks one Handler connection's outbound events. closed is closed exactly
+// once, by shutdown, to tell every goroutine holding sub (producers and the Handler's encode
+// loop) that it must stop; out itself is never closed, so a producer racing a shutdown can
+// never panic sending on a closed channel. gapPending is set by PolicyDropNewest when it
+// drops an event for a full buffer, and consumed by the Handler's encode loop, which injects
+// a gap comment the next time it dequeues a real event and so knows a slot has genuinely
+// freed up.
 type subscription struct {
 	channel     string
 	lastEventID string
 	out         chan interface{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+	gapPending  int32
+}
+
+// trySend makes a single non-blocking attempt to deliver ev, returning false without sending
+// if out's buffer is full or sub has already been shut down.
+func (sub *subscription) trySend(ev interface{}) bool {
+	select {
+	case <-sub.closed:
+		return false
+	default:
+	}
+	select {
+	case sub.out <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// send delivers ev, blocking until there is room in out's buffer or sub is shut down,
+// whichever comes first.
+func (sub *subscription) send(ev interface{}) bool {
+	select {
+	case sub.out <- ev:
+		return true
+	case <-sub.closed:
+		return false
+	}
+}
+
+// sendWithTimeout delivers ev, blocking until there is room in out's buffer, sub is shut
+// down, or timeout elapses, whichever comes first.
+func (sub *subscription) sendWithTimeout(ev interface{}, timeout time.Duration) bool {
+	select {
+	case sub.out <- ev:
+		return true
+	case <-sub.closed:
+		return false
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown tells every producer and the Handler's encode loop that sub is done. Safe to call
+// more than once or concurrently with trySend/send.
+func (sub *subscription) shutdown() {
+	sub.closeOnce.Do(func() {
+		close(sub.closed)
+	})
 }
 
 type eventOrComment interface{}
@@ -20,40 +87,144 @@ type outbound struct {
 }
 
 type registration struct {
-	channel    string
-	repository Repository
+	channel              string
+	repository           Repository
+	slowSubscriberPolicy *SlowSubscriberPolicy
 }
 
 type comment struct {
 	value string
 }
 
+// RegisterOption configures per-channel behavior when passed to Register.
+type RegisterOption interface {
+	apply(*registration)
+}
+
+type slowSubscriberPolicyOption struct {
+	policy SlowSubscriberPolicy
+}
+
+func (o slowSubscriberPolicyOption) apply(reg *registration) {
+	reg.slowSubscriberPolicy = &o.policy
+}
+
+// WithSlowSubscriberPolicy overrides Server.SlowSubscriberPolicy for the channel being
+// registered, e.g. to give a low-volume control channel a different tradeoff than a
+// high-volume data channel.
+func WithSlowSubscriberPolicy(policy SlowSubscriberPolicy) RegisterOption {
+	return slowSubscriberPolicyOption{policy: policy}
+}
+
+type slowSubscriberPolicyKind int
+
+const (
+	policyDisconnect slowSubscriberPolicyKind = iota
+	policyDropOldest
+	policyDropNewest
+	policyBlockWithTimeout
+)
+
+// SlowSubscriberPolicy determines how Server.run handles a subscriber whose out buffer is
+// already full when an event is published to it. The zero value is PolicyDisconnect.
+type SlowSubscriberPolicy struct {
+	kind    slowSubscriberPolicyKind
+	timeout time.Duration
+}
+
+var (
+	// PolicyDisconnect closes and unregisters a subscriber whose out buffer is full. This is
+	// the Server's original behavior and the default when SlowSubscriberPolicy is unset.
+	PolicyDisconnect = SlowSubscriberPolicy{kind: policyDisconnect}
+
+	// PolicyDropOldest pops the oldest buffered event for a slow subscriber and retries the
+	// send once, trading older history for keeping the subscriber connected.
+	PolicyDropOldest = SlowSubscriberPolicy{kind: policyDropOldest}
+
+	// PolicyDropNewest skips the new event for a slow subscriber, preserving whatever is
+	// already buffered, and marks a gap comment to be delivered on its stream once a slot
+	// frees up naturally, so the client knows to re-sync via Last-Event-ID.
+	PolicyDropNewest = SlowSubscriberPolicy{kind: policyDropNewest}
+)
+
+// PolicyBlockWithTimeout waits, on a separate goroutine, up to d for room in a slow
+// subscriber's buffer before falling back to PolicyDisconnect's behavior. The publisher and
+// run()'s select loop do not wait.
+func PolicyBlockWithTimeout(d time.Duration) SlowSubscriberPolicy {
+	return SlowSubscriberPolicy{kind: policyBlockWithTimeout, timeout: d}
+}
+
+// NoSnapshotCache may be implemented by a Repository to opt its channel out of snapshot
+// caching, e.g. when Replay's output for a given lastEventID is not deterministic and so
+// must not be shared between subscribers.
+type NoSnapshotCache interface {
+	DisableSnapshotCache() bool
+}
+
+// eventSnapshot accumulates the events produced by a single in-flight Replay so that
+// concurrent or near-simultaneous subscribers with the same (channel, lastEventID) can
+// share one materialization instead of each draining the Repository independently. done
+// is closed once the producer goroutine has finished appending events.
+type eventSnapshot struct {
+	mu     sync.RWMutex
+	events []Event
+	done   chan struct{}
+}
+
+func (s *eventSnapshot) append(ev Event) {
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+}
+
+func (s *eventSnapshot) snapshot() []Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.events
+}
+
 // Server manages any number of event-publishing channels and allows subscribers to consume them.
 // To use it within an HTTP server, create a handler for each channel with Handler().
 type Server struct {
-	AllowCORS     bool   // Enable all handlers to be accessible from any origin
-	ReplayAll     bool   // Replay repository even if there's no Last-Event-Id specified
-	BufferSize    int    // How many messages do we let the client get behind before disconnecting
-	Gzip          bool   // Enable compression if client can accept it
-	Logger        Logger // Logger is a logger that, when set, will be used for logging debug messages
-	registrations chan *registration
-	pub           chan *outbound
-	subs          chan *subscription
-	unregister    chan *subscription
-	quit          chan bool
-	isClosed      bool
-	isClosedMutex sync.RWMutex
+	AllowCORS            bool                 // Enable all handlers to be accessible from any origin
+	ReplayAll            bool                 // Replay repository even if there's no Last-Event-Id specified
+	BufferSize           int                  // How many messages do we let the client get behind before SlowSubscriberPolicy applies
+	Gzip                 bool                 // Enable compression if client can accept it
+	Logger               Logger               // Logger is a logger that, when set, will be used for logging debug messages
+	SnapshotCacheTTL     time.Duration        // How long a completed replay snapshot stays cached for reuse by later subscribers; concurrent/in-flight replays are always shared regardless of this setting. 0 evicts a snapshot as soon as its producer finishes, so only subscribers that arrive while the replay is still in flight share it.
+	MetricsNamespace     string               // Namespace to apply to the collector returned by Collector()
+	PublishQueueSize     int                  // Depth of the asynchronous publish ingress queue; 0 means defaultPublishQueueSize. Read once, on the first call to Publish/PublishComment/TryPublish/PublishContext; setting it after that has no effect, so it must be configured before the first publish.
+	SlowSubscriberPolicy SlowSubscriberPolicy // How to treat a subscriber whose buffer is full; zero value is PolicyDisconnect. Override per-channel with WithSlowSubscriberPolicy.
+	registrations        chan *registration
+	pub                  chan *outbound
+	subs                 chan *subscription
+	unregister           chan *subscription
+	quit                 chan bool
+	isClosed             bool
+	isClosedMutex        sync.RWMutex
+	snapCache            map[string]map[string]*eventSnapshot
+	snapCacheMutex       sync.Mutex
+	metrics              atomic.Value // stores *serverMetrics; use currentMetrics to read it
+	metricsOnce          sync.Once
+	publishCh            chan *outbound
+	publishOnce          sync.Once
+	unregisterChannel    chan string
+	goneChannels         map[string]struct{}
+	goneMutex            sync.RWMutex
 }
 
 // NewServer creates a new Server instance.
 func NewServer() *Server {
 	srv := &Server{
-		registrations: make(chan *registration),
-		pub:           make(chan *outbound),
-		subs:          make(chan *subscription),
-		unregister:    make(chan *subscription, 2),
-		quit:          make(chan bool),
-		BufferSize:    128,
+		registrations:     make(chan *registration),
+		pub:               make(chan *outbound),
+		subs:              make(chan *subscription),
+		unregister:        make(chan *subscription, 2),
+		quit:              make(chan bool),
+		BufferSize:        128,
+		snapCache:         make(map[string]map[string]*eventSnapshot),
+		unregisterChannel: make(chan string),
+		goneChannels:      make(map[string]struct{}),
 	}
 	go srv.run()
 	return srv
@@ -65,6 +236,25 @@ func (srv *Server) Close() {
 	srv.markServerClosed()
 }
 
+// Collector returns a prometheus.Collector exposing, per channel: active subscribers,
+// subscriptions opened and dropped, events dropped and published, comments published, bytes
+// written, encode errors, replays requested and actually started, and replay events emitted.
+// Register the result with a prometheus.Registry to start collecting; until Collector is called the Server does not
+// instrument itself, so callers who don't use it pay no overhead.
+func (srv *Server) Collector() prometheus.Collector {
+	srv.metricsOnce.Do(func() {
+		srv.metrics.Store(newServerMetrics(srv.MetricsNamespace))
+	})
+	return srv.currentMetrics()
+}
+
+// currentMetrics returns the Server's metrics, or nil if Collector has never been called.
+// Unlike reading the metrics field directly, it's safe to call concurrently with Collector.
+func (srv *Server) currentMetrics() *serverMetrics {
+	m, _ := srv.metrics.Load().(*serverMetrics)
+	return m
+}
+
 // Handler creates a new HTTP handler for serving a specified channel.
 func (srv *Server) Handler(channel string) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
@@ -79,6 +269,15 @@ func (srv *Server) Handler(channel string) http.HandlerFunc {
 		if useGzip {
 			h.Set("Content-Encoding", "gzip")
 		}
+
+		// A channel that has been Unregistered stays gone until it is Registered again; tell
+		// the client so it stops reconnecting instead of getting a connection that immediately
+		// closes.
+		if srv.isChannelGone(channel) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 
 		// If the Handler is still active even though the server is closed, stop here.
@@ -91,83 +290,367 @@ func (srv *Server) Handler(channel string) http.HandlerFunc {
 			channel:     channel,
 			lastEventID: req.Header.Get("Last-Event-ID"),
 			out:         make(chan interface{}, srv.BufferSize),
+			closed:      make(chan struct{}),
 		}
 		srv.subs <- sub
 		flusher := w.(http.Flusher)
 		//nolint: megacheck  // http.CloseNotifier is deprecated, but currently we are retaining compatibility with Go 1.7
 		notifier := w.(http.CloseNotifier)
 		flusher.Flush()
-		enc := NewEncoder(w, useGzip)
+		encWriter := io.Writer(w)
+		if m := srv.currentMetrics(); m != nil {
+			encWriter = meteredWriter{w: w, onWrite: func(n int) { m.bytesWrittenTo(channel, n) }}
+		}
+		enc := NewEncoder(encWriter, useGzip)
 		for {
 			select {
 			case <-notifier.CloseNotify():
 				srv.unregister <- sub
 				return
-			case ev, ok := <-sub.out:
-				if !ok {
+			case <-sub.closed:
+				// Flush any events still buffered (e.g. a final "goodbye" comment from
+				// Unregister) before tearing down the connection.
+				for {
+					select {
+					case ev := <-sub.out:
+						if err := enc.Encode(ev); err == nil {
+							flusher.Flush()
+						}
+						continue
+					default:
+					}
 					return
 				}
+			case ev := <-sub.out:
 				if err := enc.Encode(ev); err != nil {
 					srv.unregister <- sub
+					srv.currentMetrics().encodeError(channel)
 					if srv.Logger != nil {
 						srv.Logger.Println(err)
 					}
 					return
 				}
 				flusher.Flush()
+				// A real event just left the buffer, freeing a slot: if PolicyDropNewest
+				// dropped one in the meantime, tell the client about the gap now instead of
+				// evicting something else to make room for it immediately.
+				if atomic.CompareAndSwapInt32(&sub.gapPending, 1, 0) {
+					if err := enc.Encode(comment{value: "gap"}); err == nil {
+						flusher.Flush()
+					}
+				}
 			}
 		}
 	}
 }
 
-// Register registers the repository to be used for the specified channel.
-func (srv *Server) Register(channel string, repo Repository) {
-	srv.registrations <- &registration{
+// Unregister retires channel: it removes any registered Repository, disconnects current
+// subscribers with a final "goodbye" comment so they know to stop reconnecting, and causes
+// future Handler(channel) requests to respond with http.StatusGone until channel is passed to
+// Register again. Useful when an LD
+// environment is deleted or an SDK key is rotated.
+func (srv *Server) Unregister(channel string) {
+	srv.markChannelGone(channel)
+	srv.unregisterChannel <- channel
+}
+
+func (srv *Server) markChannelGone(channel string) {
+	srv.goneMutex.Lock()
+	defer srv.goneMutex.Unlock()
+	srv.goneChannels[channel] = struct{}{}
+}
+
+func (srv *Server) isChannelGone(channel string) bool {
+	srv.goneMutex.RLock()
+	defer srv.goneMutex.RUnlock()
+	_, ok := srv.goneChannels[channel]
+	return ok
+}
+
+func (srv *Server) clearChannelGone(channel string) {
+	srv.goneMutex.Lock()
+	defer srv.goneMutex.Unlock()
+	delete(srv.goneChannels, channel)
+}
+
+// Register registers the repository to be used for the specified channel. Pass
+// WithSlowSubscriberPolicy to override Server.SlowSubscriberPolicy for this channel only.
+func (srv *Server) Register(channel string, repo Repository, options ...RegisterOption) {
+	reg := &registration{
 		channel:    channel,
 		repository: repo,
 	}
+	for _, o := range options {
+		o.apply(reg)
+	}
+	srv.clearChannelGone(channel)
+	srv.registrations <- reg
 }
 
-// Publish publishes an event to one or more channels.
+// Publish publishes an event to one or more channels. It enqueues onto a buffered ingress
+// queue (see PublishQueueSize) and only blocks if that queue is full, so it is safe to call
+// from a hot path such as an FSM commit or webhook handler without waiting on run()'s
+// subscription churn and replay dispatch. Entries from a single goroutine are delivered in
+// FIFO order; use TryPublish or PublishContext for non-blocking or cancellable variants.
 func (srv *Server) Publish(channels []string, ev Event) {
-	srv.pub <- &outbound{
-		channels:       channels,
-		eventOrComment: ev,
+	for _, c := range channels {
+		srv.currentMetrics().eventPublished(c)
 	}
+	srv.enqueue(&outbound{channels: channels, eventOrComment: ev})
 }
 
-// PublishComment publishes a comment to one or more channels.
+// PublishComment publishes a comment to one or more channels. See Publish for the ingress
+// queue's blocking and ordering behavior.
 func (srv *Server) PublishComment(channels []string, text string) {
-	srv.pub <- &outbound{
-		channels:       channels,
-		eventOrComment: comment{value: text},
+	for _, c := range channels {
+		srv.currentMetrics().commentPublished(c)
+	}
+	srv.enqueue(&outbound{channels: channels, eventOrComment: comment{value: text}})
+}
+
+// TryPublish publishes an event to one or more channels without blocking. It returns false,
+// without publishing, if the ingress queue is full.
+func (srv *Server) TryPublish(channels []string, ev Event) bool {
+	srv.startPublishLoop()
+	select {
+	case srv.publishCh <- &outbound{channels: channels, eventOrComment: ev}:
+		srv.currentMetrics().setPublishQueueDepth(len(srv.publishCh))
+		for _, c := range channels {
+			srv.currentMetrics().eventPublished(c)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// PublishContext publishes an event to one or more channels, blocking until there is room in
+// the ingress queue or ctx is done, whichever comes first.
+func (srv *Server) PublishContext(ctx context.Context, channels []string, ev Event) error {
+	srv.startPublishLoop()
+	select {
+	case srv.publishCh <- &outbound{channels: channels, eventOrComment: ev}:
+		srv.currentMetrics().setPublishQueueDepth(len(srv.publishCh))
+		for _, c := range channels {
+			srv.currentMetrics().eventPublished(c)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-func replay(repo Repository, sub *subscription) {
+// enqueue puts out on the ingress queue, blocking if it is full.
+func (srv *Server) enqueue(out *outbound) {
+	srv.startPublishLoop()
+	srv.publishCh <- out
+	srv.currentMetrics().setPublishQueueDepth(len(srv.publishCh))
+}
+
+// startPublishLoop lazily creates the ingress queue, sized from PublishQueueSize as it stands
+// at the first call, and starts the goroutine that forwards it to the run() select loop.
+func (srv *Server) startPublishLoop() {
+	srv.publishOnce.Do(func() {
+		size := srv.PublishQueueSize
+		if size <= 0 {
+			size = defaultPublishQueueSize
+		}
+		srv.publishCh = make(chan *outbound, size)
+		go func() {
+			for out := range srv.publishCh {
+				srv.pub <- out
+			}
+		}()
+	})
+}
+
+func (srv *Server) replay(repo Repository, sub *subscription) {
+	srv.currentMetrics().replayStarted(sub.channel)
 	for ev := range repo.Replay(sub.channel, sub.lastEventID) {
-		sub.out <- ev
+		srv.currentMetrics().replayEventEmitted(sub.channel)
+		if !sub.send(ev) {
+			return
+		}
+	}
+}
+
+// replayWithCache serves sub's replay from the shared snapshot for (sub.channel,
+// sub.lastEventID), materializing it via repo.Replay if it is not already in flight or
+// cached. Repositories whose Replay output is not deterministic by lastEventID can opt out
+// via NoSnapshotCache and fall back to the uncached path.
+func (srv *Server) replayWithCache(repo Repository, sub *subscription) {
+	if nc, ok := repo.(NoSnapshotCache); ok && nc.DisableSnapshotCache() {
+		srv.replay(repo, sub)
+		return
+	}
+
+	srv.snapCacheMutex.Lock()
+	byLastID, ok := srv.snapCache[sub.channel]
+	if !ok {
+		byLastID = make(map[string]*eventSnapshot)
+		srv.snapCache[sub.channel] = byLastID
+	}
+	snap, ok := byLastID[sub.lastEventID]
+	if !ok {
+		snap = &eventSnapshot{done: make(chan struct{})}
+		byLastID[sub.lastEventID] = snap
+		srv.snapCacheMutex.Unlock()
+		go srv.produceSnapshot(repo, sub.channel, sub.lastEventID, snap)
+	} else {
+		srv.snapCacheMutex.Unlock()
+	}
+
+	srv.streamSnapshot(snap, sub)
+}
+
+// produceSnapshot is the single producer for a cache entry: it drains repo.Replay, appending
+// each event to snap, then closes snap.done and schedules the entry's eviction after
+// SnapshotCacheTTL.
+func (srv *Server) produceSnapshot(repo Repository, channel, lastEventID string, snap *eventSnapshot) {
+	srv.currentMetrics().replayStarted(channel)
+	for ev := range repo.Replay(channel, lastEventID) {
+		srv.currentMetrics().replayEventEmitted(channel)
+		snap.append(ev)
+	}
+	close(snap.done)
+
+	if srv.SnapshotCacheTTL <= 0 {
+		srv.evictSnapshot(channel, lastEventID, snap)
+		return
+	}
+	time.AfterFunc(srv.SnapshotCacheTTL, func() {
+		srv.evictSnapshot(channel, lastEventID, snap)
+	})
+}
+
+func (srv *Server) evictSnapshot(channel, lastEventID string, snap *eventSnapshot) {
+	srv.snapCacheMutex.Lock()
+	defer srv.snapCacheMutex.Unlock()
+	if byLastID, ok := srv.snapCache[channel]; ok && byLastID[lastEventID] == snap {
+		delete(byLastID, lastEventID)
+		if len(byLastID) == 0 {
+			delete(srv.snapCache, channel)
+		}
+	}
+}
+
+// streamSnapshot forwards snap's events to sub.out: first the events already accumulated,
+// then, once the producer finishes, anything appended after. A full sub.out falls back to
+// the existing drop/unregister path without affecting other subscribers sharing snap.
+func (srv *Server) streamSnapshot(snap *eventSnapshot, sub *subscription) {
+	events := snap.snapshot()
+	for _, ev := range events {
+		if !srv.forwardReplayEvent(sub, ev) {
+			return
+		}
+	}
+
+	<-snap.done
+
+	for _, ev := range snap.snapshot()[len(events):] {
+		if !srv.forwardReplayEvent(sub, ev) {
+			return
+		}
+	}
+}
+
+func (srv *Server) forwardReplayEvent(sub *subscription, ev Event) bool {
+	if sub.trySend(ev) {
+		return true
+	}
+	srv.unregister <- sub
+	sub.shutdown()
+	return false
+}
+
+// publishToSubscriber sends ev to s.out, applying policy only once the buffer is found full.
+// PolicyDropOldest and PolicyDropNewest keep a slow subscriber connected at the cost of
+// history; PolicyBlockWithTimeout and the default PolicyDisconnect can still disconnect it.
+// publishToSubscriber itself never waits for room in s.out: it's called inline from run()'s select loop, which
+// also handles every other channel's publishes, subscriptions, and replay dispatch, so a slow
+// subscriber here must not stall delivery to the rest of the Server.
+func (srv *Server) publishToSubscriber(s *subscription, channel string, ev eventOrComment, policy SlowSubscriberPolicy) {
+	select {
+	case s.out <- ev:
+		return
+	default:
+	}
+
+	switch policy.kind {
+	case policyDropOldest:
+		select {
+		case <-s.out:
+		default:
+		}
+		select {
+		case s.out <- ev:
+		default:
+		}
+		srv.currentMetrics().eventDropped(channel)
+	case policyDropNewest:
+		// s.out was already found full above: ev is dropped outright, and nothing already
+		// buffered is touched. gapPending is picked up by the Handler's encode loop, which
+		// delivers the gap comment once it dequeues a real event and a slot is actually free.
+		atomic.StoreInt32(&s.gapPending, 1)
+		srv.currentMetrics().eventDropped(channel)
+	case policyBlockWithTimeout:
+		// The wait runs on its own goroutine, not run()'s select loop, so a slow subscriber
+		// on this policy can't delay publishes or subscriptions on any other channel.
+		go func() {
+			if !s.sendWithTimeout(ev, policy.timeout) {
+				srv.unregister <- s
+				s.shutdown()
+				srv.currentMetrics().subscriptionDropped(channel)
+			}
+		}()
+	default: // policyDisconnect
+		srv.unregister <- s
+		s.shutdown()
+		srv.currentMetrics().subscriptionDropped(channel)
 	}
 }
 
 func (srv *Server) run() {
 	subs := make(map[string]map[*subscription]struct{})
 	repos := make(map[string]Repository)
+	policies := make(map[string]SlowSubscriberPolicy)
 	for {
 		select {
 		case reg := <-srv.registrations:
 			repos[reg.channel] = reg.repository
+			if reg.slowSubscriberPolicy != nil {
+				policies[reg.channel] = *reg.slowSubscriberPolicy
+			} else {
+				delete(policies, reg.channel)
+			}
 		case sub := <-srv.unregister:
-			delete(subs[sub.channel], sub)
+			if _, ok := subs[sub.channel][sub]; ok {
+				delete(subs[sub.channel], sub)
+				srv.currentMetrics().subscriptionClosed(sub.channel)
+			}
+		case channel := <-srv.unregisterChannel:
+			delete(repos, channel)
+			delete(policies, channel)
+			// Evict any cached replay snapshots for channel too, or a reconnecting
+			// subscriber with a matching Last-Event-ID could be replayed stale data
+			// from the repository that was just unregistered (e.g. on SDK key rotation).
+			srv.snapCacheMutex.Lock()
+			delete(srv.snapCache, channel)
+			srv.snapCacheMutex.Unlock()
+			for s := range subs[channel] {
+				s.trySend(comment{value: "goodbye"})
+				s.shutdown()
+				srv.currentMetrics().subscriptionClosed(channel)
+			}
+			delete(subs, channel)
 		case pub := <-srv.pub:
 			for _, c := range pub.channels {
+				policy, ok := policies[c]
+				if !ok {
+					policy = srv.SlowSubscriberPolicy
+				}
 				for s := range subs[c] {
-					select {
-					case s.out <- pub.eventOrComment:
-					default:
-						srv.unregister <- s
-						close(s.out)
-					}
+					srv.publishToSubscriber(s, c, pub.eventOrComment, policy)
 				}
 			}
 		case sub := <-srv.subs:
@@ -175,16 +658,19 @@ func (srv *Server) run() {
 				subs[sub.channel] = make(map[*subscription]struct{})
 			}
 			subs[sub.channel][sub] = struct{}{}
+			srv.currentMetrics().subscriptionOpened(sub.channel)
 			if srv.ReplayAll || len(sub.lastEventID) > 0 {
 				repo, ok := repos[sub.channel]
 				if ok {
-					go replay(repo, sub)
+					srv.currentMetrics().replayRequested(sub.channel)
+					go srv.replayWithCache(repo, sub)
 				}
 			}
 		case <-srv.quit:
-			for _, sub := range subs {
+			for channel, sub := range subs {
 				for s := range sub {
-					close(s.out)
+					s.shutdown()
+					srv.currentMetrics().subscriptionClosed(channel)
 				}
 			}
 			return
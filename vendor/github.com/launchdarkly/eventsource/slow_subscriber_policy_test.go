@@ -0,0 +1,115 @@
+package eventsource
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFullSub(channel string) *subscription {
+	sub := &subscription{
+		channel: channel,
+		out:     make(chan interface{}, 1),
+		closed:  make(chan struct{}),
+	}
+	sub.out <- fakeEvent{id: "0", data: "first"}
+	return sub
+}
+
+// TestPublishToSubscriberPolicies covers each SlowSubscriberPolicy's effect on a subscriber
+// whose buffer is already full.
+func TestPublishToSubscriberPolicies(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	t.Run("PolicyDisconnect drops the subscriber", func(t *testing.T) {
+		sub := newFullSub("ch")
+		srv.publishToSubscriber(sub, "ch", fakeEvent{id: "1", data: "second"}, PolicyDisconnect)
+		select {
+		case <-sub.closed:
+		case <-time.After(time.Second):
+			t.Fatal("expected PolicyDisconnect to shut down a full subscriber")
+		}
+	})
+
+	t.Run("PolicyDropOldest keeps the subscriber and delivers the newest event", func(t *testing.T) {
+		sub := newFullSub("ch")
+		srv.publishToSubscriber(sub, "ch", fakeEvent{id: "1", data: "second"}, PolicyDropOldest)
+		select {
+		case <-sub.closed:
+			t.Fatal("PolicyDropOldest must not disconnect the subscriber")
+		default:
+		}
+		if ev := (<-sub.out).(fakeEvent); ev.data != "second" {
+			t.Fatalf("expected the newest event to survive, got %+v", ev)
+		}
+	})
+
+	t.Run("PolicyDropNewest keeps the subscriber, preserves buffered history, and marks a gap", func(t *testing.T) {
+		sub := newFullSub("ch")
+		srv.publishToSubscriber(sub, "ch", fakeEvent{id: "1", data: "second"}, PolicyDropNewest)
+		select {
+		case <-sub.closed:
+			t.Fatal("PolicyDropNewest must not disconnect the subscriber")
+		default:
+		}
+		if atomic.LoadInt32(&sub.gapPending) != 1 {
+			t.Fatal("expected PolicyDropNewest to mark a gap pending")
+		}
+		if ev := (<-sub.out).(fakeEvent); ev.data != "first" {
+			t.Fatalf("expected the already-buffered event to survive untouched, got %+v", ev)
+		}
+	})
+
+	t.Run("PolicyBlockWithTimeout disconnects a still-full subscriber without blocking the caller", func(t *testing.T) {
+		sub := newFullSub("ch")
+		start := time.Now()
+		srv.publishToSubscriber(sub, "ch", fakeEvent{id: "1", data: "second"}, PolicyBlockWithTimeout(50*time.Millisecond))
+		if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+			t.Fatalf("PolicyBlockWithTimeout must return immediately rather than block run()'s caller; took %v", elapsed)
+		}
+		select {
+		case <-sub.closed:
+		case <-time.After(time.Second):
+			t.Fatal("expected PolicyBlockWithTimeout to eventually disconnect a still-full subscriber")
+		}
+	})
+}
+
+// TestSlowSubscriberPolicyBlockDoesNotStallOtherChannels is a regression test for run()'s
+// central select loop blocking on one channel's PolicyBlockWithTimeout wait and starving
+// publishes to every other channel for up to the full timeout.
+func TestSlowSubscriberPolicyBlockDoesNotStallOtherChannels(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.Register("slow", &countingRepo{}, WithSlowSubscriberPolicy(PolicyBlockWithTimeout(300*time.Millisecond)))
+	srv.Register("fast", &countingRepo{})
+
+	slowSub := &subscription{channel: "slow", out: make(chan interface{}, 1), closed: make(chan struct{})}
+	fastSub := &subscription{channel: "fast", out: make(chan interface{}, 1), closed: make(chan struct{})}
+	srv.subs <- slowSub
+	srv.subs <- fastSub
+
+	// Fill the slow subscriber's buffer so the next publish to it has to invoke the policy.
+	srv.Publish([]string{"slow"}, fakeEvent{id: "1", data: "a"})
+	select {
+	case <-slowSub.out:
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber never received its first event")
+	}
+	slowSub.out <- fakeEvent{id: "2", data: "b"} // re-fill the buffer directly, bypassing run()
+
+	start := time.Now()
+	srv.Publish([]string{"slow"}, fakeEvent{id: "3", data: "c"})
+	srv.Publish([]string{"fast"}, fakeEvent{id: "4", data: "d"})
+
+	select {
+	case <-fastSub.out:
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("fast channel publish took %v; run() appears stalled by the slow channel's PolicyBlockWithTimeout wait", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast channel never received its event; run() appears stalled")
+	}
+}
@@ -0,0 +1,127 @@
+package eventsource
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEvent is the minimal Event implementation used across these tests.
+type fakeEvent struct {
+	id   string
+	data string
+}
+
+func (e fakeEvent) Id() string    { return e.id }
+func (e fakeEvent) Event() string { return "" }
+func (e fakeEvent) Data() string  { return e.data }
+
+// countingRepo is a Repository that records how many times Replay was actually invoked, so
+// tests can tell a shared snapshot from one Replay per subscriber.
+type countingRepo struct {
+	mu     sync.Mutex
+	calls  int
+	events []Event
+}
+
+func (r *countingRepo) Replay(channel, lastEventID string) chan Event {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+
+	ch := make(chan Event, len(r.events))
+	for _, ev := range r.events {
+		ch <- ev
+	}
+	close(ch)
+	return ch
+}
+
+func (r *countingRepo) callCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+// TestReplayWithCacheSharesSnapshotAcrossConcurrentSubscribers covers the chunk0-1 thundering
+// herd case: many subscribers reconnecting with the same (channel, lastEventID) at once must
+// share one Repository.Replay invocation rather than each triggering their own.
+func TestReplayWithCacheSharesSnapshotAcrossConcurrentSubscribers(t *testing.T) {
+	repo := &countingRepo{events: []Event{fakeEvent{id: "1", data: "a"}}}
+	srv := NewServer()
+	defer srv.Close()
+	srv.SnapshotCacheTTL = time.Minute
+	srv.Register("ch", repo)
+
+	const n = 10
+	var wg sync.WaitGroup
+	outs := make([]chan interface{}, n)
+	for i := 0; i < n; i++ {
+		sub := &subscription{channel: "ch", lastEventID: "0", out: make(chan interface{}, 4), closed: make(chan struct{})}
+		outs[i] = sub.out
+		wg.Add(1)
+		go func(s *subscription) {
+			defer wg.Done()
+			srv.subs <- s
+		}(sub)
+	}
+	wg.Wait()
+
+	for _, out := range outs {
+		select {
+		case <-out:
+		case <-time.After(time.Second):
+			t.Fatal("subscriber never received its replayed event")
+		}
+	}
+
+	if calls := repo.callCount(); calls != 1 {
+		t.Fatalf("expected exactly 1 Repository.Replay call for %d subscribers sharing a cache entry, got %d", n, calls)
+	}
+}
+
+// TestUnregisterRaceWithConcurrentPublishAndSubscribe exercises Unregister racing against
+// concurrent Publish and new subscribers on the same channel; run with -race. Each
+// subscriber's out is drained by its own goroutine so publishToSubscriber never has to
+// invoke a slow-subscriber policy; that's covered separately by
+// TestPublishToSubscriberPolicies and isn't what this test is checking.
+func TestUnregisterRaceWithConcurrentPublishAndSubscribe(t *testing.T) {
+	repo := &countingRepo{events: []Event{fakeEvent{id: "1", data: "a"}}}
+	srv := NewServer()
+	defer srv.Close()
+	srv.Register("ch", repo)
+
+	const publishes, subscribers = 200, 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < publishes; i++ {
+			srv.Publish([]string{"ch"}, fakeEvent{id: "x", data: "y"})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < subscribers; i++ {
+			sub := &subscription{channel: "ch", lastEventID: "0", out: make(chan interface{}, 8), closed: make(chan struct{})}
+			srv.subs <- sub
+			go func() {
+				for {
+					select {
+					case <-sub.out:
+					case <-sub.closed:
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		srv.Unregister("ch")
+		srv.Register("ch", repo)
+	}
+	wg.Wait()
+}